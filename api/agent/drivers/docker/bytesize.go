@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	byteSize = 1
+	kilobyte = 1024 * byteSize
+	megabyte = 1024 * kilobyte
+	gigabyte = 1024 * megabyte
+	terabyte = 1024 * gigabyte
+)
+
+var byteUnits = []struct {
+	suffix string
+	mul    int64
+}{
+	{"TB", terabyte},
+	{"GB", gigabyte},
+	{"MB", megabyte},
+	{"KB", kilobyte},
+	{"B", byteSize},
+}
+
+// ParseBytes parses a human-friendly size string such as "512MB" or "64GB" into a number of
+// bytes. A bare number (e.g. "1073741824") is treated as a byte count. Units are binary
+// multiples of 1024 and are case-insensitive.
+func ParseBytes(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return 0, fmt.Errorf("invalid size %q: empty", s)
+	}
+
+	upper := strings.ToUpper(trimmed)
+	multiplier := int64(byteSize)
+	numPart := upper
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			multiplier = unit.mul
+			numPart = strings.TrimSuffix(upper, unit.suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(numPart), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("invalid size %q: must not be negative", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
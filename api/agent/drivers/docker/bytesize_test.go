@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"testing"
+
+	d "github.com/fsouza/go-dockerclient"
+)
+
+func TestParseBytes(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"512B", 512},
+		{"1KB", 1024},
+		{"64MB", 64 * 1024 * 1024},
+		{"2GB", 2 * 1024 * 1024 * 1024},
+		{"1TB", 1024 * 1024 * 1024 * 1024},
+		{"1.5GB", int64(1.5 * 1024 * 1024 * 1024)},
+		{"64gb", 64 * 1024 * 1024 * 1024},
+		{"  64GB  ", 64 * 1024 * 1024 * 1024},
+	}
+	for _, tc := range cases {
+		got, err := ParseBytes(tc.in)
+		if err != nil {
+			t.Errorf("ParseBytes(%q) returned error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseBytes(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseBytesInvalid(t *testing.T) {
+	for _, in := range []string{"", "   ", "GB", "-1GB", "abcMB"} {
+		if _, err := ParseBytes(in); err == nil {
+			t.Errorf("ParseBytes(%q) did not return an error", in)
+		}
+	}
+}
+
+func TestWithMaxSize(t *testing.T) {
+	c := NewCache(0, WithMaxSize("1KB"))
+	if got, want := c.maxSize, int64(1024); got != want {
+		t.Fatalf("maxSize = %d, want %d", got, want)
+	}
+}
+
+func TestCacheSizeAccountingAndEvictTo(t *testing.T) {
+	c := NewCache(0)
+	defer c.Close()
+
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	c.Add(d.APIImages{ID: "b", Size: 20})
+	if got, want := c.TotalSize(), int64(30); got != want {
+		t.Fatalf("TotalSize() = %d, want %d", got, want)
+	}
+	if !c.OverFilled() {
+		t.Fatal("OverFilled() false with totalSize > maxSize")
+	}
+
+	evicted := c.EvictTo(10)
+	if c.TotalSize() > 10 {
+		t.Fatalf("TotalSize() = %d after EvictTo(10), still over target", c.TotalSize())
+	}
+	if len(evicted) == 0 {
+		t.Fatal("EvictTo(10) evicted nothing despite being over target")
+	}
+}
+
+func TestCacheFallsBackToVirtualSize(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	c.Add(d.APIImages{ID: "a", VirtualSize: 42})
+	if got, want := c.TotalSize(), int64(42); got != want {
+		t.Fatalf("TotalSize() = %d, want %d (VirtualSize fallback)", got, want)
+	}
+}
@@ -1,18 +1,26 @@
 /*
 ImageCache holds all the logic for calculating what docker images can be removed from the running agent.
-The last used time and the number of uses are both taken into account to calculate a score (timeSinceLastUse/uses)
-The higher the score the more evicitable the image is.
+
+Which entry to evict is decided by a pluggable Policy (see policy.go): the cache itself only owns the
+map of image ID -> Entry, size accounting, and locking, and defers all scoring and ordering decisions
+to the configured Policy. The default is PolicySIEVE; PolicyLRUK, PolicyLFU and Policy2Q are also
+available and can be selected with WithPolicy, since hot-function image reuse patterns differ sharply
+from cold batch runs and no single policy wins on every workload.
 
 ImageCache also provides a method to "lock" an image, insuring it is never deleted. To do so a Lock is called with
-the image ID to lock, as well as a token. The token is then added to a set of tokens attached to that entry.
-The set is a map of *interface -> *interface where both values are the same.
+the image ID to lock, as well as a token; the token must be comparable, since it is used as a map key so the
+same logical token passed to Unlock can find and remove it. LockWithTTL attaches an expiry to the token instead,
+and a background sweeper started by NewCache clears expired tokens on its own, so a crashed function invocation
+cannot pin an image forever. TryLock is the non-blocking form of LockWithTTL: instead of erroring when ID isn't
+in the cache yet, it returns false so a caller racing an in-flight pull can poll rather than treat the miss as
+a failure.
 */
 
 package docker
 
 import (
 	"errors"
-	"sort"
+	"math"
 	"sync"
 	"time"
 
@@ -20,48 +28,165 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Cache is an LRU cache, safe for concurrent access.
+// Cache is an image cache, safe for concurrent access, whose eviction order is delegated to a Policy.
 type Cache struct {
-	totalSize int64
 	mu        sync.Mutex
-	cache     EntryByAge
 	maxSize   int64
+	totalSize int64
+
+	elements map[string]*Entry
+	policy   Policy
+
+	onEvict  func(d.APIImages, EvictReason)
+	onAccess func(image d.APIImages, prevUses int64)
+
+	hits, misses, adds, evictions int64
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// leaseSweepInterval is how often the background goroutine checks for expired LockWithTTL leases.
+const leaseSweepInterval = 10 * time.Second
+
+// Option configures a Cache at construction time.
+type Option func(*Cache)
+
+// WithMaxSize sets the cache's maximum total image size from a human-friendly string such as
+// "64GB", overriding the maxSize passed to NewCache. This lets operators configure the agent's
+// on-disk image budget from config/env without doing byte math.
+func WithMaxSize(size string) Option {
+	return func(c *Cache) {
+		n, err := ParseBytes(size)
+		if err != nil {
+			logrus.WithError(err).WithField("size", size).Error("invalid docker image cache max size, ignoring")
+			return
+		}
+		c.maxSize = n
+	}
+}
+
+// WithPolicy selects the eviction scoring strategy. The default, if this option is not given, is
+// PolicySIEVE.
+func WithPolicy(p Policy) Option {
+	return func(c *Cache) {
+		c.policy = p
+	}
+}
+
+// WithOnEvict registers a callback invoked every time an entry leaves the cache, along with the
+// EvictReason, so the agent can emit Prometheus counters for image churn and alert on thrash
+// before it manifests as a user-visible cold-start regression.
+func WithOnEvict(f func(d.APIImages, EvictReason)) Option {
+	return func(c *Cache) {
+		c.onEvict = f
+	}
+}
+
+// WithOnAccess registers a callback invoked whenever Add or Mark touches an entry. prevUses is
+// the entry's use count immediately before this access, so a zero prevUses tells an integrator
+// this is the image's first use rather than a repeat.
+func WithOnAccess(f func(image d.APIImages, prevUses int64)) Option {
+	return func(c *Cache) {
+		c.onAccess = f
+	}
 }
 
 type Entry struct {
 	lastUsed time.Time
-	locked   map[*interface{}]*interface{}
+	locked   map[interface{}]time.Time // token -> expiry; zero time.Time means no expiry
 	uses     int64
 	image    d.APIImages
 }
 
+// Score is age since last use divided by use count: higher means a better eviction candidate. An
+// entry with a zero use count is scored as maximally evictable rather than dividing by zero.
 func (e Entry) Score() int64 {
+	if e.uses == 0 {
+		return math.MaxInt64
+	}
 	age := time.Now().Sub(e.lastUsed)
 	return age.Nanoseconds() / e.uses
 }
 
-type EntryByAge []Entry
+func (e *Entry) isLocked() bool { return len(e.locked) > 0 }
 
-func (a EntryByAge) Len() int           { return len(a) }
-func (a EntryByAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a EntryByAge) Less(i, j int) bool { return a[i].Score() < a[j].Score() }
+// EntryByAge is the result type of Evictable. Despite the name, entries are returned in the order
+// the configured Policy would evict them, not sorted by age.
+type EntryByAge []Entry
 
 func NewEntry(value d.APIImages) Entry {
 	return Entry{
 		lastUsed: time.Now(),
-		locked:   make(map[*interface{}]*interface{}),
+		locked:   make(map[interface{}]time.Time),
 		uses:     0,
 		image:    value}
 }
 
-// New returns a new cache with the provided maximum items.
-func NewCache(maxSize int64) *Cache {
-	return &Cache{
-		cache: make(EntryByAge, 0),
-		mu:    sync.Mutex{},
+// NewCache returns a new cache with the provided maximum total image size, in bytes. It starts a
+// background goroutine that expires stale LockWithTTL leases; call Close to stop it.
+func NewCache(maxSize int64, opts ...Option) *Cache {
+	c := &Cache{
+		elements: make(map[string]*Entry),
+		maxSize:  maxSize,
+		policy:   NewPolicySIEVE(),
+		stopCh:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	go c.sweepLeases()
+	return c
+}
+
+// Close stops the cache's background lease sweeper. It is safe to call more than once.
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() { close(c.stopCh) })
+}
+
+func (c *Cache) sweepLeases() {
+	t := time.NewTicker(leaseSweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.expireLeases()
+		case <-c.stopCh:
+			return
+		}
 	}
 }
 
+func (c *Cache) expireLeases() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, e := range c.elements {
+		for token, expiry := range e.locked {
+			if !expiry.IsZero() && now.After(expiry) {
+				delete(e.locked, token)
+			}
+		}
+	}
+
+	// A lock expiring may be the only thing that was keeping an over-budget image pinned;
+	// reclaim space now instead of waiting for the next EvictTo call.
+	for c.totalSize > c.maxSize {
+		if _, ok := c.evictOne(ReasonLeaseSweep); !ok {
+			break
+		}
+	}
+}
+
+// imageSize returns the best size estimate go-dockerclient gives us for an image: Size when
+// the daemon reported one, falling back to VirtualSize otherwise.
+func imageSize(img d.APIImages) int64 {
+	if img.Size > 0 {
+		return img.Size
+	}
+	return img.VirtualSize
+}
+
 func (c *Cache) Contains(value d.APIImages) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -69,14 +194,10 @@ func (c *Cache) Contains(value d.APIImages) bool {
 }
 
 func (c *Cache) contains(value d.APIImages) bool {
-	for _, i := range c.cache {
-		if i.image.ID == value.ID {
-			return true
-		}
-	}
-	return false
-
+	_, ok := c.elements[value.ID]
+	return ok
 }
+
 func (c *Cache) Mark(ID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -84,33 +205,47 @@ func (c *Cache) Mark(ID string) error {
 }
 
 func (c *Cache) mark(ID string) error {
-	for idx, i := range c.cache {
-		if i.image.ID == ID {
-			c.cache[idx].lastUsed = time.Now()
-			c.cache[idx].uses = c.cache[idx].uses + 1
-			return nil
-		}
+	e, ok := c.elements[ID]
+	if !ok {
+		c.misses++
+		return errors.New("Image not found in cache")
 	}
-
-	return errors.New("Image not found in cache")
+	prevUses := e.uses
+	e.lastUsed = time.Now()
+	e.uses = e.uses + 1
+	c.policy.OnHit(e)
+	c.hits++
+	if c.onAccess != nil {
+		c.onAccess(e.image, prevUses)
+	}
+	return nil
 }
 
 func (c *Cache) Remove(value d.APIImages) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	for idx, i := range c.cache {
-		if i.image.ID == value.ID {
-			// Move the last item into the location of the item to be removed
-			c.cache[idx] = c.cache[len(c.cache)-1]
-			// shorten the list
-			c.cache = c.cache[:len(c.cache)-1]
-			return nil
-		}
-	}
+	return c.remove(value.ID, ReasonManual)
+}
 
-	return errors.New("Image not found in cache")
+func (c *Cache) remove(id string, reason EvictReason) error {
+	e, ok := c.elements[id]
+	if !ok {
+		return errors.New("Image not found in cache")
+	}
+	img := e.image
+	c.totalSize -= imageSize(img)
+	delete(c.elements, id)
+	c.policy.OnRemove(e)
+	c.evictions++
+	if c.onEvict != nil {
+		c.onEvict(img, reason)
+	}
+	return nil
 }
 
+// Lock locks ID with key, which must be comparable, so that it is never evicted until Unlock
+// is called with the same key. The lock never expires on its own; use LockWithTTL for a lock
+// that should be released automatically.
 func (c *Cache) Lock(ID string, key interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -118,13 +253,40 @@ func (c *Cache) Lock(ID string, key interface{}) error {
 }
 
 func (c *Cache) lock(ID string, key interface{}) error {
-	for _, i := range c.cache {
-		if i.image.ID == ID {
-			i.locked[&key] = &key
-			return nil
-		}
+	e, ok := c.elements[ID]
+	if !ok {
+		return errors.New("Image not found in cache")
 	}
-	return errors.New("Image not found in cache")
+	e.locked[key] = time.Time{}
+	return nil
+}
+
+// LockWithTTL locks ID with key as Lock does, but the lock expires automatically after ttl so a
+// crashed function invocation cannot pin an image forever.
+func (c *Cache) LockWithTTL(ID string, key interface{}, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.elements[ID]
+	if !ok {
+		return errors.New("Image not found in cache")
+	}
+	e.locked[key] = time.Now().Add(ttl)
+	return nil
+}
+
+// TryLock attempts to lock ID with key, with the same expiry semantics as LockWithTTL, but never
+// blocks and never errors on a cache miss: it returns false if ID isn't in the cache (for example
+// because the image hasn't finished pulling yet, or was just evicted), so a caller racing the
+// cache can poll instead of treating the miss as a failure.
+func (c *Cache) TryLock(ID string, key interface{}, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.elements[ID]
+	if !ok {
+		return false, nil
+	}
+	e.locked[key] = time.Now().Add(ttl)
+	return true, nil
 }
 
 func (c *Cache) Locked(ID string) (bool, error) {
@@ -134,12 +296,11 @@ func (c *Cache) Locked(ID string) (bool, error) {
 }
 
 func (c *Cache) locked(ID string) (bool, error) {
-	for _, i := range c.cache {
-		if i.image.ID == ID {
-			return len(i.locked) > 0, nil
-		}
+	e, ok := c.elements[ID]
+	if !ok {
+		return false, errors.New("Image not found in cache")
 	}
-	return false, errors.New("Image not found in cache")
+	return e.isLocked(), nil
 }
 
 func (c *Cache) Unlock(ID string, key interface{}) {
@@ -149,11 +310,27 @@ func (c *Cache) Unlock(ID string, key interface{}) {
 }
 
 func (c *Cache) unlock(ID string, key interface{}) {
-	for _, i := range c.cache {
-		if i.image.ID == ID {
-			delete(i.locked, &key)
-		}
+	e, ok := c.elements[ID]
+	if !ok {
+		return
+	}
+	delete(e.locked, key)
+}
+
+// LockedBy returns the tokens currently holding a lock on ID, for observability into who is
+// pinning an image. It returns nil if ID is not in the cache.
+func (c *Cache) LockedBy(ID string) []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.elements[ID]
+	if !ok {
+		return nil
 	}
+	tokens := make([]interface{}, 0, len(e.locked))
+	for token := range e.locked {
+		tokens = append(tokens, token)
+	}
+	return tokens
 }
 
 // Add adds the provided key and value to the cache, evicting
@@ -166,38 +343,97 @@ func (c *Cache) Add(value d.APIImages) {
 		c.mark(value.ID)
 		return
 	}
-	c.cache = append(c.cache, NewEntry(value))
+	e := NewEntry(value)
+	ep := &e
+	ep.uses = 1 // the add itself counts as the entry's first use
+	c.elements[value.ID] = ep
+	c.totalSize += imageSize(value)
+	c.adds++
+	c.policy.OnAdd(ep)
+	if c.onAccess != nil {
+		c.onAccess(value, 0)
+	}
 }
 
+// TotalSize returns the combined size, in bytes, of every image currently in the cache.
 func (c *Cache) TotalSize() int64 {
-	return 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totalSize
 }
 
 func (c *Cache) OverFilled() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.TotalSize() > c.maxSize
+	return c.totalSize > c.maxSize
 }
 
+// Evictable returns the entries the configured policy would evict, in the order it would evict
+// them, without actually removing anything from the cache or disturbing the policy's bookkeeping:
+// it drains a Clone of the policy rather than the live one, so it's safe to call as often as the
+// docker prune loop likes.
 func (c *Cache) Evictable() EntryByAge {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.evictable()
 }
 
-func (c *Cache) evictable() (ea EntryByAge) {
-	for _, i := range c.cache {
-		if len(i.locked) == 0 {
-			ea = append(ea, i)
+func (c *Cache) evictable() EntryByAge {
+	preview := c.policy.Clone()
+
+	var ea EntryByAge
+	for {
+		id, ok := preview.Victim()
+		if !ok {
+			break
+		}
+		e, ok := c.elements[id]
+		if !ok {
+			break
 		}
+		ea = append(ea, *e)
+		preview.OnRemove(e)
 	}
-	sort.Sort(ea)
 	return ea
 }
 
+// evictOne asks the policy for its next victim and removes it from the cache for the given
+// reason, reporting false if nothing is evictable (every entry is locked, or the cache is empty).
+func (c *Cache) evictOne(reason EvictReason) (d.APIImages, bool) {
+	id, ok := c.policy.Victim()
+	if !ok {
+		return d.APIImages{}, false
+	}
+	e, ok := c.elements[id]
+	if !ok {
+		return d.APIImages{}, false
+	}
+	img := e.image
+	c.remove(id, reason)
+	return img, true
+}
+
+// EvictTo evicts entries, in the order the configured policy chooses them, until the cache's
+// total size is at or below target or there is nothing left to evict, and returns the evicted
+// images so the caller (the docker driver) can remove them from the daemon.
+func (c *Cache) EvictTo(target int64) []d.APIImages {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var evicted []d.APIImages
+	for c.totalSize > target {
+		img, ok := c.evictOne(ReasonSize)
+		if !ok {
+			break
+		}
+		evicted = append(evicted, img)
+	}
+	return evicted
+}
+
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return len(c.cache)
+	return len(c.elements)
 }
@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	d "github.com/fsouza/go-dockerclient"
+)
+
+func TestCacheAddMarkContains(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	img := d.APIImages{ID: "a", Size: 10}
+	if c.Contains(img) {
+		t.Fatal("empty cache reports Contains true")
+	}
+
+	c.Add(img)
+	if !c.Contains(img) {
+		t.Fatal("Contains false right after Add")
+	}
+	if got, want := c.Len(), 1; got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+
+	if err := c.Mark("a"); err != nil {
+		t.Fatalf("Mark: %v", err)
+	}
+	if err := c.Mark("missing"); err == nil {
+		t.Fatal("Mark on unknown ID did not return an error")
+	}
+}
+
+func TestCacheAddIsIdempotentPerID(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	img := d.APIImages{ID: "a", Size: 10}
+	c.Add(img)
+	c.Add(img) // re-adding a known ID should mark it, not duplicate it
+
+	if got, want := c.Len(), 1; got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+	if got, want := c.TotalSize(), int64(10); got != want {
+		t.Fatalf("TotalSize() = %d, want %d", got, want)
+	}
+}
+
+func TestCacheRemove(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	img := d.APIImages{ID: "a", Size: 10}
+	c.Add(img)
+	if err := c.Remove(img); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if c.Contains(img) {
+		t.Fatal("Contains true after Remove")
+	}
+	if err := c.Remove(img); err == nil {
+		t.Fatal("Remove of an already-removed ID did not return an error")
+	}
+}
+
+func TestCacheLockUnlock(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	img := d.APIImages{ID: "a", Size: 10}
+	c.Add(img)
+
+	locked, err := c.Locked("a")
+	if err != nil {
+		t.Fatalf("Locked: %v", err)
+	}
+	if locked {
+		t.Fatal("Locked true before any Lock call")
+	}
+
+	if err := c.Lock("a", "token1"); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	locked, err = c.Locked("a")
+	if err != nil {
+		t.Fatalf("Locked: %v", err)
+	}
+	if !locked {
+		t.Fatal("Locked false after Lock")
+	}
+
+	by := c.LockedBy("a")
+	if len(by) != 1 || by[0] != "token1" {
+		t.Fatalf("LockedBy = %v, want [token1]", by)
+	}
+
+	c.Unlock("a", "token1")
+	locked, err = c.Locked("a")
+	if err != nil {
+		t.Fatalf("Locked: %v", err)
+	}
+	if locked {
+		t.Fatal("Locked true after Unlock")
+	}
+}
+
+func TestCacheTryLock(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	ok, err := c.TryLock("missing", "token1", time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock on missing ID returned an error: %v", err)
+	}
+	if ok {
+		t.Fatal("TryLock on missing ID returned true")
+	}
+
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	ok, err = c.TryLock("a", "token1", time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	if !ok {
+		t.Fatal("TryLock on a present ID returned false")
+	}
+	locked, err := c.Locked("a")
+	if err != nil {
+		t.Fatalf("Locked: %v", err)
+	}
+	if !locked {
+		t.Fatal("Locked false after a successful TryLock")
+	}
+}
+
+func TestCacheLockPreventsEviction(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	c.Add(d.APIImages{ID: "b", Size: 10})
+	if err := c.Lock("a", "token1"); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	evicted := c.EvictTo(0)
+	for _, img := range evicted {
+		if img.ID == "a" {
+			t.Fatalf("locked entry %q was evicted", img.ID)
+		}
+	}
+	if !c.Contains(d.APIImages{ID: "a"}) {
+		t.Fatal("locked entry was removed from the cache")
+	}
+}
@@ -0,0 +1,62 @@
+package docker
+
+// EvictReason identifies why an entry left the cache, for the OnEvict callback and for
+// distinguishing eviction causes in logs and metrics.
+type EvictReason int
+
+const (
+	// ReasonSize means the entry was evicted by EvictTo to bring the cache's total size down to
+	// the target passed to it.
+	ReasonSize EvictReason = iota
+	// ReasonManual means the entry was removed via an explicit Remove call.
+	ReasonManual
+	// ReasonLeaseSweep means the entry was evicted by the background lease sweeper, which
+	// opportunistically reclaims space once an expired lock stops pinning an over-budget image.
+	// A lease expiring does not by itself fire OnEvict; only an entry the sweeper then evicts does.
+	ReasonLeaseSweep
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonSize:
+		return "size"
+	case ReasonManual:
+		return "manual"
+	case ReasonLeaseSweep:
+		return "lease-sweep"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats is a point-in-time snapshot of cache activity and contents, suitable for exporting as
+// Prometheus counters/gauges.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Adds      int64
+	Evictions int64
+	Size      int64
+	Uses      map[string]int64 // image ID -> use count
+}
+
+// Stats returns the cache's current hit/miss/add/eviction counters, its total byte size, and a
+// per-image use count.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uses := make(map[string]int64, len(c.elements))
+	for id, e := range c.elements {
+		uses[id] = e.uses
+	}
+
+	return Stats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Adds:      c.adds,
+		Evictions: c.evictions,
+		Size:      c.totalSize,
+		Uses:      uses,
+	}
+}
@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"testing"
+
+	d "github.com/fsouza/go-dockerclient"
+)
+
+func TestCacheStats(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	c.Add(d.APIImages{ID: "b", Size: 20})
+	c.Mark("a")
+	c.Mark("missing")
+	c.Remove(d.APIImages{ID: "b"})
+
+	stats := c.Stats()
+	if stats.Adds != 2 {
+		t.Errorf("Adds = %d, want 2", stats.Adds)
+	}
+	if stats.Hits != 1 {
+		t.Errorf("Hits = %d, want 1", stats.Hits)
+	}
+	if stats.Misses != 1 {
+		t.Errorf("Misses = %d, want 1", stats.Misses)
+	}
+	if stats.Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", stats.Evictions)
+	}
+	if stats.Size != 10 {
+		t.Errorf("Size = %d, want 10", stats.Size)
+	}
+	if got, want := stats.Uses["a"], int64(2); got != want {
+		t.Errorf("Uses[a] = %d, want %d", got, want)
+	}
+}
+
+func TestCacheOnEvictReasons(t *testing.T) {
+	var reasons []EvictReason
+	c := NewCache(0, WithOnEvict(func(img d.APIImages, reason EvictReason) {
+		reasons = append(reasons, reason)
+	}))
+	defer c.Close()
+
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	c.Add(d.APIImages{ID: "b", Size: 10})
+	c.Remove(d.APIImages{ID: "a"})
+	c.EvictTo(0)
+
+	if len(reasons) != 2 {
+		t.Fatalf("onEvict fired %d times, want 2: %v", len(reasons), reasons)
+	}
+	if reasons[0] != ReasonManual {
+		t.Errorf("first eviction reason = %v, want %v", reasons[0], ReasonManual)
+	}
+	if reasons[1] != ReasonSize {
+		t.Errorf("second eviction reason = %v, want %v", reasons[1], ReasonSize)
+	}
+}
+
+func TestCacheOnAccessPrevUses(t *testing.T) {
+	var prevUses []int64
+	c := NewCache(1<<30, WithOnAccess(func(img d.APIImages, prevUse int64) {
+		prevUses = append(prevUses, prevUse)
+	}))
+	defer c.Close()
+
+	c.Add(d.APIImages{ID: "a", Size: 10}) // first use: prevUses == 0
+	c.Mark("a")                           // second use: prevUses == 1
+	c.Mark("a")                           // third use: prevUses == 2
+
+	want := []int64{0, 1, 2}
+	if len(prevUses) != len(want) {
+		t.Fatalf("onAccess fired %d times, want %d: %v", len(prevUses), len(want), prevUses)
+	}
+	for i := range want {
+		if prevUses[i] != want[i] {
+			t.Errorf("prevUses[%d] = %d, want %d", i, prevUses[i], want[i])
+		}
+	}
+}
+
+func TestEvictReasonString(t *testing.T) {
+	cases := map[EvictReason]string{
+		ReasonSize:       "size",
+		ReasonManual:     "manual",
+		ReasonLeaseSweep: "lease-sweep",
+		EvictReason(99):  "unknown",
+	}
+	for reason, want := range cases {
+		if got := reason.String(); got != want {
+			t.Errorf("EvictReason(%d).String() = %q, want %q", reason, got, want)
+		}
+	}
+}
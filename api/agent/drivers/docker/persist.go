@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	d "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// snapshotEntry is the on-disk representation of a Cache entry. Locks are intentionally not
+// persisted: a lock only makes sense for the lifetime of the process that created the token.
+type snapshotEntry struct {
+	ID       string    `json:"id"`
+	LastUsed time.Time `json:"last_used"`
+	Uses     int64     `json:"uses"`
+	Size     int64     `json:"size"`
+}
+
+// Snapshot writes the cache's current entries (image ID, last-used timestamp, use count and
+// size) to w as JSON, so they can be restored with Restore after an agent restart.
+func (c *Cache) Snapshot(w io.Writer) error {
+	c.mu.Lock()
+	entries := make([]snapshotEntry, 0, len(c.elements))
+	for _, e := range c.elements {
+		entries = append(entries, snapshotEntry{
+			ID:       e.image.ID,
+			LastUsed: e.lastUsed,
+			Uses:     e.uses,
+			Size:     imageSize(e.image),
+		})
+	}
+	c.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// Restore reads entries written by Snapshot and adds them to the cache, skipping any ID already
+// present. Callers should reconcile the restored entries against the daemon's actual image list
+// (e.g. via docker.ListImages) to drop entries whose image is gone and add newly discovered ones.
+func (c *Cache) Restore(r io.Reader) error {
+	var entries []snapshotEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, se := range entries {
+		if _, ok := c.elements[se.ID]; ok {
+			continue
+		}
+		e := &Entry{
+			lastUsed: se.LastUsed,
+			locked:   make(map[interface{}]time.Time),
+			uses:     se.Uses,
+			image:    d.APIImages{ID: se.ID, Size: se.Size},
+		}
+		c.elements[se.ID] = e
+		c.totalSize += se.Size
+		c.policy.OnAdd(e)
+	}
+	return nil
+}
+
+// NewCacheFromFile returns a new cache, like NewCache, with its entries restored from a
+// previously written Snapshot at path. A missing file is not an error: it just means this is the
+// first time the agent has started with persistence enabled.
+func NewCacheFromFile(path string, maxSize int64, opts ...Option) *Cache {
+	c := NewCache(maxSize, opts...)
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("path", path).Error("could not open docker image cache snapshot")
+		}
+		return c
+	}
+	defer f.Close()
+
+	if err := c.Restore(f); err != nil {
+		logrus.WithError(err).WithField("path", path).Error("could not restore docker image cache snapshot")
+	}
+	return c
+}
+
+// PersistEvery starts a goroutine that writes a Snapshot to path every interval, so cache state
+// survives an agent restart instead of resetting all counters to zero. It stops when the cache
+// is Closed.
+func (c *Cache) PersistEvery(interval time.Duration, path string) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := c.persistTo(path); err != nil {
+					logrus.WithError(err).WithField("path", path).Error("could not persist docker image cache snapshot")
+				}
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// persistTo writes the snapshot to a temp file alongside path and renames it into place, so a
+// crash or kill mid-write can never leave a truncated or corrupt snapshot at path.
+func (c *Cache) persistTo(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := c.Snapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	d "github.com/fsouza/go-dockerclient"
+)
+
+func TestSnapshotRestoreRoundTrip(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	c.Add(d.APIImages{ID: "b", Size: 20})
+	c.Mark("a")
+
+	var buf bytes.Buffer
+	if err := c.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	restored := NewCache(1 << 30)
+	defer restored.Close()
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if !restored.Contains(d.APIImages{ID: "a"}) || !restored.Contains(d.APIImages{ID: "b"}) {
+		t.Fatalf("restored cache missing entries: %+v", restored.elements)
+	}
+	if got, want := restored.elements["a"].uses, int64(2); got != want {
+		t.Fatalf("restored use count = %d, want %d", got, want)
+	}
+	if got, want := restored.TotalSize(), int64(30); got != want {
+		t.Fatalf("restored total size = %d, want %d", got, want)
+	}
+}
+
+func TestRestoreSkipsExistingEntries(t *testing.T) {
+	c := NewCache(1 << 30)
+	defer c.Close()
+	c.Add(d.APIImages{ID: "a", Size: 10})
+	c.Mark("a")
+
+	var buf bytes.Buffer
+	if err := c.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// "a" is already present with uses=1 (the add itself); restoring must not clobber it with the
+	// snapshot's uses=2.
+	c2 := NewCache(1 << 30)
+	defer c2.Close()
+	c2.Add(d.APIImages{ID: "a", Size: 10})
+	if err := c2.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got, want := c2.elements["a"].uses, int64(1); got != want {
+		t.Fatalf("Restore overwrote an existing entry: uses = %d, want %d", got, want)
+	}
+}
+
+func TestPersistToIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.json")
+
+	c := NewCache(1 << 30)
+	defer c.Close()
+	c.Add(d.APIImages{ID: "a", Size: 10})
+
+	if err := c.persistTo(path); err != nil {
+		t.Fatalf("persistTo: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temp file %q was not cleaned up: err=%v", path+".tmp", err)
+	}
+
+	restored := NewCacheFromFile(path, 1<<30)
+	defer restored.Close()
+	if !restored.Contains(d.APIImages{ID: "a"}) {
+		t.Fatalf("NewCacheFromFile did not restore entry written by persistTo")
+	}
+}
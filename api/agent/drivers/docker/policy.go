@@ -0,0 +1,412 @@
+package docker
+
+import "container/list"
+
+// Policy decides which entry a Cache evicts next. Implementations are not expected to be safe
+// for concurrent access on their own; Cache serializes all calls under its own mutex.
+type Policy interface {
+	// OnAdd is called when a new entry is added to the cache.
+	OnAdd(e *Entry)
+	// OnHit is called when an existing entry is accessed, via Add or Mark.
+	OnHit(e *Entry)
+	// OnRemove is called when an entry leaves the cache, whether by eviction or manual removal.
+	OnRemove(e *Entry)
+	// Victim returns the ID of the entry the policy would evict next, skipping locked entries.
+	// ok is false if there is nothing evictable.
+	Victim() (id string, ok bool)
+	// Clone returns a deep copy of the policy's own bookkeeping (queues, buckets, hand position,
+	// visited bits, etc). It still points at the same *Entry values, but Victim/OnRemove/OnAdd
+	// on the clone never mutate the original's bookkeeping, so Cache.Evictable can preview
+	// eviction order by draining a clone instead of the live policy.
+	Clone() Policy
+}
+
+// PolicyLRUK scores entries by time since last use divided by use count, the ratio the original
+// linear-scan cache used, and picks the highest-scoring unlocked entry as its victim.
+type PolicyLRUK struct {
+	entries map[string]*Entry
+}
+
+// NewPolicyLRUK returns a PolicyLRUK ready for use.
+func NewPolicyLRUK() *PolicyLRUK {
+	return &PolicyLRUK{entries: make(map[string]*Entry)}
+}
+
+func (p *PolicyLRUK) OnAdd(e *Entry)    { p.entries[e.image.ID] = e }
+func (p *PolicyLRUK) OnHit(e *Entry)    {}
+func (p *PolicyLRUK) OnRemove(e *Entry) { delete(p.entries, e.image.ID) }
+
+func (p *PolicyLRUK) Victim() (string, bool) {
+	var victim *Entry
+	var best int64
+	for _, e := range p.entries {
+		if e.isLocked() {
+			continue
+		}
+		score := e.Score()
+		if victim == nil || score > best {
+			victim, best = e, score
+		}
+	}
+	if victim == nil {
+		return "", false
+	}
+	return victim.image.ID, true
+}
+
+func (p *PolicyLRUK) Clone() Policy {
+	cp := NewPolicyLRUK()
+	for id, e := range p.entries {
+		cp.entries[id] = e
+	}
+	return cp
+}
+
+// lfuBucket holds every entry currently at a given use-frequency.
+type lfuBucket struct {
+	freq    int64
+	entries map[string]*Entry
+}
+
+// PolicyLFU is a classic O(1) LFU: buckets are kept in a linked list ordered by ascending
+// frequency, and a hit moves an entry to the next bucket (creating it if needed), so eviction
+// and promotion never need to rescan every entry.
+type PolicyLFU struct {
+	buckets *list.List
+	node    map[string]*list.Element // id -> the bucket element currently holding it
+}
+
+// NewPolicyLFU returns a PolicyLFU ready for use.
+func NewPolicyLFU() *PolicyLFU {
+	return &PolicyLFU{
+		buckets: list.New(),
+		node:    make(map[string]*list.Element),
+	}
+}
+
+// bucketAt returns the existing bucket at freq, or creates and inserts one in order.
+func (p *PolicyLFU) bucketAt(freq int64) *list.Element {
+	for el := p.buckets.Front(); el != nil; el = el.Next() {
+		b := el.Value.(*lfuBucket)
+		if b.freq == freq {
+			return el
+		}
+		if b.freq > freq {
+			return p.buckets.InsertBefore(&lfuBucket{freq: freq, entries: make(map[string]*Entry)}, el)
+		}
+	}
+	return p.buckets.PushBack(&lfuBucket{freq: freq, entries: make(map[string]*Entry)})
+}
+
+func (p *PolicyLFU) OnAdd(e *Entry) {
+	el := p.bucketAt(1)
+	el.Value.(*lfuBucket).entries[e.image.ID] = e
+	p.node[e.image.ID] = el
+}
+
+func (p *PolicyLFU) OnHit(e *Entry) {
+	id := e.image.ID
+	el, ok := p.node[id]
+	if !ok {
+		return
+	}
+	b := el.Value.(*lfuBucket)
+	delete(b.entries, id)
+
+	// Buckets are in ascending frequency order, so the freq+1 bucket, if it exists, is el's
+	// immediate neighbour.
+	next := el.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != b.freq+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{freq: b.freq + 1, entries: make(map[string]*Entry)}, el)
+	}
+	next.Value.(*lfuBucket).entries[id] = e
+	p.node[id] = next
+
+	if len(b.entries) == 0 {
+		p.buckets.Remove(el)
+	}
+}
+
+func (p *PolicyLFU) OnRemove(e *Entry) {
+	id := e.image.ID
+	el, ok := p.node[id]
+	if !ok {
+		return
+	}
+	b := el.Value.(*lfuBucket)
+	delete(b.entries, id)
+	delete(p.node, id)
+	if len(b.entries) == 0 {
+		p.buckets.Remove(el)
+	}
+}
+
+// Victim returns an unlocked entry from the lowest-frequency bucket that holds one.
+func (p *PolicyLFU) Victim() (string, bool) {
+	for el := p.buckets.Front(); el != nil; el = el.Next() {
+		for id, e := range el.Value.(*lfuBucket).entries {
+			if !e.isLocked() {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *PolicyLFU) Clone() Policy {
+	cp := NewPolicyLFU()
+	for el := p.buckets.Front(); el != nil; el = el.Next() {
+		b := el.Value.(*lfuBucket)
+		nb := &lfuBucket{freq: b.freq, entries: make(map[string]*Entry, len(b.entries))}
+		newEl := cp.buckets.PushBack(nb)
+		for id, e := range b.entries {
+			nb.entries[id] = e
+			cp.node[id] = newEl
+		}
+	}
+	return cp
+}
+
+// Default queue sizes for Policy2Q, expressed as a count of entries rather than bytes since the
+// queues only ever hold pointers (and, for A1out, bare IDs).
+const (
+	default2QA1InMax  = 256
+	default2QA1OutMax = 512
+)
+
+// Policy2Q implements the 2Q algorithm. New entries enter the A1in FIFO; when one falls off the
+// end of A1in its ID lingers as a ghost in A1out. A hit while an ID is still a ghost in A1out
+// promotes the entry straight into Am, an LRU queue, on the theory that a second reference soon
+// after the first means the image is hot rather than part of a one-off scan.
+type Policy2Q struct {
+	a1in  *list.List // FIFO of *Entry
+	a1out *list.List // FIFO of ghost IDs (string)
+	am    *list.List // LRU of *Entry
+
+	a1inNode  map[string]*list.Element
+	a1outNode map[string]*list.Element
+	amNode    map[string]*list.Element
+}
+
+// NewPolicy2Q returns a Policy2Q ready for use, with default queue sizes.
+func NewPolicy2Q() *Policy2Q {
+	return &Policy2Q{
+		a1in:      list.New(),
+		a1out:     list.New(),
+		am:        list.New(),
+		a1inNode:  make(map[string]*list.Element),
+		a1outNode: make(map[string]*list.Element),
+		amNode:    make(map[string]*list.Element),
+	}
+}
+
+func (p *Policy2Q) OnAdd(e *Entry) {
+	id := e.image.ID
+	if el, ok := p.a1outNode[id]; ok {
+		p.a1out.Remove(el)
+		delete(p.a1outNode, id)
+		p.amNode[id] = p.am.PushFront(e)
+		return
+	}
+	p.a1inNode[id] = p.a1in.PushFront(e)
+	p.trimA1In()
+}
+
+func (p *Policy2Q) trimA1In() {
+	for p.a1in.Len() > default2QA1InMax {
+		tail := p.a1in.Back()
+		e := tail.Value.(*Entry)
+		p.a1in.Remove(tail)
+		delete(p.a1inNode, e.image.ID)
+		p.a1outNode[e.image.ID] = p.a1out.PushFront(e.image.ID)
+		p.trimA1Out()
+	}
+}
+
+func (p *Policy2Q) trimA1Out() {
+	for p.a1out.Len() > default2QA1OutMax {
+		tail := p.a1out.Back()
+		p.a1out.Remove(tail)
+		delete(p.a1outNode, tail.Value.(string))
+	}
+}
+
+func (p *Policy2Q) OnHit(e *Entry) {
+	id := e.image.ID
+	if el, ok := p.a1outNode[id]; ok {
+		p.a1out.Remove(el)
+		delete(p.a1outNode, id)
+		p.amNode[id] = p.am.PushFront(e)
+		return
+	}
+	// A1in is a FIFO by design: a hit there doesn't move the entry. Only Am is recency-ordered.
+	if el, ok := p.amNode[id]; ok {
+		p.am.MoveToFront(el)
+	}
+}
+
+func (p *Policy2Q) OnRemove(e *Entry) {
+	id := e.image.ID
+	if el, ok := p.a1inNode[id]; ok {
+		p.a1in.Remove(el)
+		delete(p.a1inNode, id)
+		return
+	}
+	if el, ok := p.amNode[id]; ok {
+		p.am.Remove(el)
+		delete(p.amNode, id)
+	}
+}
+
+// Victim prefers the oldest unlocked entry in A1in, since it hasn't proven itself hot, and falls
+// back to the LRU tail of Am.
+func (p *Policy2Q) Victim() (string, bool) {
+	for el := p.a1in.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*Entry); !e.isLocked() {
+			return e.image.ID, true
+		}
+	}
+	for el := p.am.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*Entry); !e.isLocked() {
+			return e.image.ID, true
+		}
+	}
+	return "", false
+}
+
+// cloneEntryList copies a list of *Entry values into a fresh list, preserving order, and
+// returns the id -> element index alongside it.
+func cloneEntryList(src *list.List) (*list.List, map[string]*list.Element) {
+	dst := list.New()
+	nodes := make(map[string]*list.Element, src.Len())
+	for el := src.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*Entry)
+		nodes[e.image.ID] = dst.PushBack(e)
+	}
+	return dst, nodes
+}
+
+// cloneGhostList copies a list of bare string IDs (A1out) into a fresh list, preserving order.
+func cloneGhostList(src *list.List) (*list.List, map[string]*list.Element) {
+	dst := list.New()
+	nodes := make(map[string]*list.Element, src.Len())
+	for el := src.Front(); el != nil; el = el.Next() {
+		id := el.Value.(string)
+		nodes[id] = dst.PushBack(id)
+	}
+	return dst, nodes
+}
+
+func (p *Policy2Q) Clone() Policy {
+	cp := &Policy2Q{}
+	cp.a1in, cp.a1inNode = cloneEntryList(p.a1in)
+	cp.am, cp.amNode = cloneEntryList(p.am)
+	cp.a1out, cp.a1outNode = cloneGhostList(p.a1out)
+	return cp
+}
+
+// sieveNode pairs an Entry with the visited bit SIEVE needs for it. The bit lives here, not on
+// Entry itself, so a cloned PolicySIEVE can flip its own copies without touching the original's
+// (or any other policy's) view of the same Entry.
+type sieveNode struct {
+	entry   *Entry
+	visited bool
+}
+
+// PolicySIEVE implements the SIEVE algorithm: entries live on a doubly-linked list and each
+// carries a single "visited" bit that is set on a hit. A persistent "hand" pointer walks the
+// list from tail to head looking for a victim: a visited entry has its bit cleared and is
+// skipped, an unvisited unlocked entry is the victim. Unlike LRU, a hit never moves the entry in
+// the list.
+type PolicySIEVE struct {
+	elements map[string]*list.Element // id -> element wrapping a *sieveNode
+	order    *list.List
+	hand     *list.Element // nil until the policy has looked for a victim at least once
+}
+
+// NewPolicySIEVE returns a PolicySIEVE ready for use.
+func NewPolicySIEVE() *PolicySIEVE {
+	return &PolicySIEVE{
+		elements: make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (p *PolicySIEVE) OnAdd(e *Entry) {
+	p.elements[e.image.ID] = p.order.PushFront(&sieveNode{entry: e})
+}
+
+func (p *PolicySIEVE) OnHit(e *Entry) {
+	if el, ok := p.elements[e.image.ID]; ok {
+		el.Value.(*sieveNode).visited = true
+	}
+}
+
+func (p *PolicySIEVE) OnRemove(e *Entry) {
+	el, ok := p.elements[e.image.ID]
+	if !ok {
+		return
+	}
+	if p.hand == el {
+		p.hand = p.prev(el)
+	}
+	p.order.Remove(el)
+	delete(p.elements, e.image.ID)
+	if p.order.Len() == 0 {
+		p.hand = nil
+	}
+}
+
+// prev returns the element before el in hand-walk order, wrapping from the head to the tail.
+func (p *PolicySIEVE) prev(el *list.Element) *list.Element {
+	if prev := el.Prev(); prev != nil {
+		return prev
+	}
+	return p.order.Back()
+}
+
+// Victim advances the hand, clearing visited bits as it goes, and returns the first unvisited,
+// unlocked entry it finds. Locked entries are skipped without clearing their visited bit.
+func (p *PolicySIEVE) Victim() (string, bool) {
+	n := p.order.Len()
+	if n == 0 {
+		return "", false
+	}
+	if p.hand == nil {
+		p.hand = p.order.Back()
+	}
+	// Two full passes: the first may only clear visited bits, the second then finds any
+	// unlocked entry.
+	for i := 0; i < 2*n; i++ {
+		el := p.hand
+		node := el.Value.(*sieveNode)
+		if node.entry.isLocked() {
+			p.hand = p.prev(el)
+			continue
+		}
+		if node.visited {
+			node.visited = false
+			p.hand = p.prev(el)
+			continue
+		}
+		return node.entry.image.ID, true
+	}
+	return "", false
+}
+
+func (p *PolicySIEVE) Clone() Policy {
+	cp := NewPolicySIEVE()
+	var handID string
+	if p.hand != nil {
+		handID = p.hand.Value.(*sieveNode).entry.image.ID
+	}
+	for el := p.order.Front(); el != nil; el = el.Next() {
+		node := el.Value.(*sieveNode)
+		newEl := cp.order.PushBack(&sieveNode{entry: node.entry, visited: node.visited})
+		cp.elements[node.entry.image.ID] = newEl
+	}
+	if handID != "" {
+		cp.hand = cp.elements[handID]
+	}
+	return cp
+}
@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"testing"
+
+	d "github.com/fsouza/go-dockerclient"
+)
+
+// newTestEntry builds a detached Entry for exercising a Policy directly, without a Cache.
+func newTestEntry(id string) *Entry {
+	e := NewEntry(d.APIImages{ID: id, Size: 1})
+	return &e
+}
+
+// TestPolicyCloneIsIndependent verifies that draining a Policy's Clone via Victim/OnRemove never
+// mutates the original's bookkeeping, which is what makes Cache.Evictable a safe, repeatable
+// preview instead of something that corrupts live eviction order on every call.
+func TestPolicyCloneIsIndependent(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+	}{
+		{"LRUK", NewPolicyLRUK()},
+		{"LFU", NewPolicyLFU()},
+		{"2Q", NewPolicy2Q()},
+		{"SIEVE", NewPolicySIEVE()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.policy
+			a := newTestEntry("a")
+			b := newTestEntry("b")
+			p.OnAdd(a)
+			p.OnAdd(b)
+			// Give "a" some access history so a naive remove-then-readd would visibly reset it
+			// (e.g. an LFU frequency bucket or a SIEVE visited bit; 2Q leaves an A1in entry in place).
+			p.OnHit(a)
+			p.OnHit(a)
+			p.OnHit(a)
+
+			entries := map[string]*Entry{"a": a, "b": b}
+			before := drainVictimOrder(p.Clone(), entries)
+
+			// Draining a clone to build an Evictable() preview must not touch p itself.
+			_ = drainVictimOrder(p.Clone(), entries)
+
+			after := drainVictimOrder(p.Clone(), entries)
+			if len(before) != len(after) {
+				t.Fatalf("victim order length changed after preview: before=%v after=%v", before, after)
+			}
+			for i := range before {
+				if before[i] != after[i] {
+					t.Fatalf("victim order changed after preview: before=%v after=%v", before, after)
+				}
+			}
+		})
+	}
+}
+
+// drainVictimOrder fully drains a (throwaway) policy clone via Victim/OnRemove, capturing the
+// order it would evict entries in.
+func drainVictimOrder(p Policy, entries map[string]*Entry) []string {
+	var order []string
+	for {
+		id, ok := p.Victim()
+		if !ok {
+			break
+		}
+		order = append(order, id)
+		p.OnRemove(entries[id])
+	}
+	return order
+}
+
+// TestCacheEvictableIsRepeatable is the end-to-end regression test: calling Evictable() twice in
+// a row must return the same order, and must not perturb the entries' real scoring state.
+func TestCacheEvictableIsRepeatable(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+	}{
+		{"LRUK", NewPolicyLRUK()},
+		{"LFU", NewPolicyLFU()},
+		{"2Q", NewPolicy2Q()},
+		{"SIEVE", NewPolicySIEVE()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewCache(1<<30, WithPolicy(tc.policy))
+			defer c.Close()
+
+			imgA := d.APIImages{ID: "a", Size: 1}
+			imgB := d.APIImages{ID: "b", Size: 1}
+			c.Add(imgA)
+			c.Add(imgB)
+			// Promote "a" so it would rank behind "b" in eviction order for every policy here
+			// except 2Q, whose A1in is a FIFO that hits do not reorder.
+			c.Mark("a")
+			c.Mark("a")
+
+			first := c.Evictable()
+			second := c.Evictable()
+
+			if len(first) != len(second) {
+				t.Fatalf("Evictable() length changed between calls: first=%d second=%d", len(first), len(second))
+			}
+			for i := range first {
+				if first[i].image.ID != second[i].image.ID {
+					t.Fatalf("Evictable() order changed between calls: first=%v second=%v", ids(first), ids(second))
+				}
+			}
+		})
+	}
+}
+
+func ids(entries EntryByAge) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.image.ID
+	}
+	return out
+}